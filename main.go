@@ -1,8 +1,14 @@
 package main
 
 import (
+	"encoding/binary"
+	"flag"
+	"fmt"
 	"math"
 	"math/rand/v2"
+	"os"
+	"runtime"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -15,105 +21,569 @@ const (
 	numStars     = 300
 	centerX      = windowWidth / 2
 	centerY      = windowHeight / 2
+
+	// half-width of the square (at z = zFar) the default profile spawns stars in, in world units
+	// centered on the view axis; the other spawn profiles scale their sizes off it
+	spawnExtent = windowWidth / 4
+	// focal length used for the perspective projection, in the same world units as x/y/z
+	focalLength = windowWidth / 2
+	// z distance a freshly spawned star starts at, and the distance brightness is normalized against
+	zFar = 1000.0
+	// z distance at which a star is considered to have passed the camera and is respawned
+	zNear = 1.0
 )
 
-type position struct {
-	x, y float64
+// a 3D point in world space
+type point3 struct {
+	x, y, z float64
 }
 
-// a struct to represent a star, with its position, speed and brightness
+// a struct to represent a star, with its 3D position, brightness and on-screen projection
 type Star struct {
-	pos        position
-	speed      position
-	brightness byte
+	pos, prevPos point3
+	brightness   byte
+	// hue/sat are the star's stellar-temperature color, assigned once at spawn; color is that
+	// hue/sat re-rendered at the star's current brightness (as V), cached each update for draw
+	hue, sat float64
+	color    rgb
+	// cached screen-space projection of pos and prevPos from the last update, used by draw to
+	// stroke a streak from the previous position to the current one
+	screenX, screenY         int
+	prevScreenX, prevScreenY int
+	onScreen                 bool
 }
 
 // support struct to keep track of all stars and update/draw them
 type Stars struct {
 	stars         []Star
 	minWarpFactor float64
+	// colorMode selects temperature-based coloring (true) or the original monochrome look
+	// (false); toggled at runtime with the C key (sdl.K_c)
+	colorMode bool
+	// lazily initialized persistent pool backing draw; nil until the first draw call
+	pool *renderPool
+}
+
+// project a world-space point to screen coordinates via perspective division.
+// returns ok=false if the point is behind the camera or falls outside the viewport.
+func project(p point3) (x, y int, ok bool) {
+	if p.z <= zNear {
+		return 0, 0, false
+	}
+	sx := centerX + (p.x/p.z)*focalLength
+	sy := centerY + (p.y/p.z)*focalLength
+	if sx < 0 || sx >= windowWidth || sy < 0 || sy >= windowHeight {
+		return 0, 0, false
+	}
+	return int(sx), int(sy), true
 }
 
-// generate a random float64 between min and max using a PCG random generator
+// package-level RNG used by randFloat64. Defaults to a time-based seed so tests and benchmarks
+// that call newStar directly still get varied output; main reseeds it from -seed so a run (and
+// anything recorded from it) is reproducible.
+var rng = rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), uint64(time.Now().UnixMicro())))
+
+// seedRNG reseeds the package-level RNG, making every subsequent randFloat64 draw - and so every
+// newStar spawned from here on - deterministic for a given seed.
+func seedRNG(seed uint64) {
+	rng = rand.New(rand.NewPCG(seed, seed))
+}
+
+// generate a random float64 between min and max, drawing from the package-level PCG generator
 func randFloat64(min, max float64) float64 {
-	r := rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), uint64(time.Now().UnixMicro())))
-	return min + (max-min)*r.Float64()
+	return min + (max-min)*rng.Float64()
 }
 
-// clear the pixel buffer by setting all values to 0 (black)
-func clearPixels(pixels []byte) {
-	for i := range pixels {
+// clear a horizontal slice of the pixel buffer, from row yStart (inclusive) to yEnd (exclusive),
+// by setting all of its bytes to 0 (black)
+func clearTile(pixels []byte, yStart, yEnd int) {
+	rowBytes := windowWidth * 4
+	for i := yStart * rowBytes; i < yEnd*rowBytes; i++ {
 		pixels[i] = 0
 	}
 }
 
-// set a pixel in the pixel buffer to a specific color (in this case, it's black and white so set everything to the same brightness	value)
+// an 8-bit RGB color
+type rgb struct {
+	r, g, b byte
+}
+
+// set a pixel in the pixel buffer to a specific color
 // the buffer is a linear array of bytes, where each pixel is represented by 4 bytes (A, B, G, R), so we need to calculate the index of the pixel we want to set
-// by multiplying the y coordinate by the width of the window and adding the x coordinate, then multiplying by 4 to get the index of the first byte of the pixel (A), and then setting the R, G and B values to the same brightness value. Note that
-func setPixel(pixels []byte, x, y int, c byte) {
+// by multiplying the y coordinate by the width of the window and adding the x coordinate, then multiplying by 4 to get the index of the first byte of the pixel (A), and then setting the R, G and B values. Note that
+func setPixel(pixels []byte, x, y int, c rgb) {
 	index := (y*windowWidth + x) * 4
 	if index > 0 && index < len(pixels)-4 {
-		pixels[index] = c   // R
-		pixels[index+1] = c // G
-		pixels[index+2] = c // B
+		pixels[index] = c.r   // R
+		pixels[index+1] = c.g // G
+		pixels[index+2] = c.b // B
 	}
 }
 
-func newStar() Star {
-	angle := randFloat64(-math.Pi, math.Pi)
-	speed := 255 * math.Pow(randFloat64(float64(0.3), float64(1.0)), 2)
+// setPixelWeighted sets a pixel to c scaled by weight (0-1), as used by drawStreak to split each
+// sub-pixel sample's intensity between its two neighbouring rows/columns
+func setPixelWeighted(pixels []byte, x, y int, c rgb, weight float64) {
+	setPixel(pixels, x, y, rgb{
+		r: byte(float64(c.r) * weight),
+		g: byte(float64(c.g) * weight),
+		b: byte(float64(c.b) * weight),
+	})
+}
 
-	// calculate the direction of the star based on the angle
-	dx := math.Cos(angle)
-	dy := math.Sin(angle)
+// hsvToRGB converts a hue in [0,360), saturation and value in [0,1] to 8-bit RGB.
+func hsvToRGB(h, s, v float64) rgb {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
 
-	d := rand.IntN(int(math.Round(float64(windowWidth)/8))) + 1
+	var r1, g1, b1 float64
+	switch {
+	case h < 60:
+		r1, g1, b1 = c, x, 0
+	case h < 120:
+		r1, g1, b1 = x, c, 0
+	case h < 180:
+		r1, g1, b1 = 0, c, x
+	case h < 240:
+		r1, g1, b1 = 0, x, c
+	case h < 300:
+		r1, g1, b1 = x, 0, c
+	default:
+		r1, g1, b1 = c, 0, x
+	}
 
-	// and then calculate the initial position of the star based on the center of the window and the direction
-	pos := position{centerX + dx*float64(d), centerY + dy*float64(d)}
-	// finally calculate the speed of the star based on the direction and the speed value we generated earlier
-	speedPos := position{dx * speed, dy * speed}
+	return rgb{
+		r: byte((r1 + m) * 255),
+		g: byte((g1 + m) * 255),
+		b: byte((b1 + m) * 255),
+	}
+}
+
+// drawStreak draws an anti-aliased line from (x0,y0) to (x1,y1) using Xiaolin Wu's algorithm,
+// representing a star's motion since the previous frame. For the shallow case (|dx| >= |dy|) it
+// steps along x, plotting two vertically adjacent pixels per column weighted by the fractional
+// y-distance; the steep case is the transpose. A zero-length line (a star that just spawned, or
+// hasn't moved) falls back to plotting a single full-weight pixel.
+//
+// Writes are clipped to rows [yMin, yMax): a streak can span a tile boundary at high warp, and a
+// renderPool worker must never touch rows outside the tile it owns while another worker is
+// concurrently clearing and drawing its own tile.
+func drawStreak(pixels []byte, x0, y0, x1, y1 int, c rgb, yMin, yMax int) {
+	plot := func(x, y int, weight float64) {
+		// setPixel's own bounds check is a flat index check, so an x of -1 or windowWidth would
+		// wrap into the next/previous row instead of being dropped; check both axes here first
+		if x < 0 || x >= windowWidth || y < yMin || y >= yMax {
+			return
+		}
+		setPixelWeighted(pixels, x, y, c, weight)
+	}
+
+	if x0 == x1 && y0 == y1 {
+		plot(x0, y0, 1)
+		return
+	}
+
+	fx0, fy0, fx1, fy1 := float64(x0), float64(y0), float64(x1), float64(y1)
+	dx, dy := fx1-fx0, fy1-fy0
+
+	if math.Abs(dx) >= math.Abs(dy) {
+		if fx0 > fx1 {
+			fx0, fy0, fx1, fy1 = fx1, fy1, fx0, fy0
+		}
+		gradient := (fy1 - fy0) / (fx1 - fx0)
+		y := fy0
+		for x := int(fx0); x <= int(fx1); x++ {
+			yFloor := math.Floor(y)
+			frac := y - yFloor
+			plot(x, int(yFloor), 1-frac)
+			plot(x, int(yFloor)+1, frac)
+			y += gradient
+		}
+		return
+	}
+
+	if fy0 > fy1 {
+		fx0, fy0, fx1, fy1 = fx1, fy1, fx0, fy0
+	}
+	gradient := (fx1 - fx0) / (fy1 - fy0)
+	x := fx0
+	for y := int(fy0); y <= int(fy1); y++ {
+		xFloor := math.Floor(x)
+		frac := x - xFloor
+		plot(int(xFloor), y, 1-frac)
+		plot(int(xFloor)+1, y, frac)
+		x += gradient
+	}
+}
+
+// starColorClass is a stellar-temperature category: a hue/saturation pair approximating its
+// black-body color, and the relative weight used when picking a color for a new star.
+type starColorClass struct {
+	hue, sat float64
+	weight   float64
+}
+
+// color classes from hot blue-white down to cool red giants, weighted so the great majority of
+// stars render white/blue-white with occasional yellow, orange and red giants mixed in
+var starColorClasses = []starColorClass{
+	{hue: 220, sat: 0.15, weight: 0.55}, // blue-white
+	{hue: 0, sat: 0, weight: 0.30},      // white
+	{hue: 45, sat: 0.55, weight: 0.08},  // yellow
+	{hue: 30, sat: 0.70, weight: 0.05},  // orange
+	{hue: 10, sat: 0.85, weight: 0.02},  // red giant
+}
+
+// pick a star's hue/saturation from the stellar-temperature distribution in starColorClasses
+func randomStarColor() (hue, sat float64) {
+	total := 0.0
+	for _, class := range starColorClasses {
+		total += class.weight
+	}
+
+	pick := randFloat64(0, total)
+	for _, class := range starColorClasses {
+		if pick < class.weight {
+			return class.hue, class.sat
+		}
+		pick -= class.weight
+	}
+	last := starColorClasses[len(starColorClasses)-1]
+	return last.hue, last.sat
+}
+
+const (
+	// characteristic sizes for the galactic spawn profiles below, scaled off spawnExtent
+	bulgeSigma      = spawnExtent / 3
+	diskScaleLength = spawnExtent / 2
+	hernquistScale  = spawnExtent / 2
+)
+
+// SpawnFunc produces a world-space spawn point (relative to the view axis, with z as an offset
+// from the far plane) for a new star. newStar draws from whichever SpawnFunc is selected at
+// startup via -profile, so the demo can visualize different stellar density models.
+type SpawnFunc func(rng *rand.Rand) (x, y, z float64)
+
+// spawnFuncs maps a -profile flag value to its SpawnFunc.
+var spawnFuncs = map[string]SpawnFunc{
+	"disk":        uniformSquareSpawn,
+	"bulge":       gaussianBulgeSpawn,
+	"exponential": exponentialDiskSpawn,
+	"hernquist":   hernquistSpawn,
+}
+
+// spawnFunc is the active spawn distribution, selected at startup via -profile.
+var spawnFunc SpawnFunc = uniformSquareSpawn
+
+// uniformSquareSpawn samples x and y independently and uniformly over [-spawnExtent,
+// spawnExtent]. This is the original spawn behavior (a uniform square, not actually a disk,
+// despite the "disk" -profile name it's registered under).
+func uniformSquareSpawn(rng *rand.Rand) (x, y, z float64) {
+	sample := func() float64 { return -spawnExtent + 2*spawnExtent*rng.Float64() }
+	return sample(), sample(), 0
+}
+
+// gaussianBulgeSpawn samples a Gaussian bulge centered on the view axis, like the dense core of
+// a galaxy.
+func gaussianBulgeSpawn(rng *rand.Rand) (x, y, z float64) {
+	return rng.NormFloat64() * bulgeSigma, rng.NormFloat64() * bulgeSigma, 0
+}
+
+// exponentialDiskSpawn samples a radius from an exponential-disk profile, the classic galactic
+// brightness falloff, via inverse-CDF sampling, paired with a uniform-random angle.
+func exponentialDiskSpawn(rng *rand.Rand) (x, y, z float64) {
+	r := -diskScaleLength * math.Log(1-rng.Float64())
+	theta := rng.Float64() * 2 * math.Pi
+	return r * math.Cos(theta), r * math.Sin(theta), 0
+}
+
+// hernquistSpawn samples a radius from a Hernquist profile via inverse-CDF sampling
+// (r = Rs * sqrt(u)/(1-sqrt(u)) for u ~ U(0,1)) and a uniform-random direction on the sphere, approximating the
+// density profile used for galactic bulges and dark-matter halos in N-body models.
+func hernquistSpawn(rng *rand.Rand) (x, y, z float64) {
+	u := math.Sqrt(rng.Float64())
+	r := hernquistScale * u / (1 - u)
+
+	cosTheta := 2*rng.Float64() - 1
+	sinTheta := math.Sqrt(1 - cosTheta*cosTheta)
+	phi := 2 * math.Pi * rng.Float64()
+
+	return r * sinTheta * math.Cos(phi), r * sinTheta * math.Sin(phi), r * cosTheta
+}
+
+// spawn a star at a position drawn from spawnFunc, near the far plane
+func newStar() Star {
+	x, y, zOffset := spawnFunc(rng)
+	pos := point3{
+		x: x,
+		y: y,
+		z: zFar + zOffset,
+	}
+	hue, sat := randomStarColor()
 
 	star := Star{
+		// prevPos starts equal to pos. A fresh star is not drawn (onScreen is false) until its
+		// first update, which projects its streak from this spawn position, so no stray line
+		// runs back from wherever the respawned star used to be
 		pos:        pos,
-		speed:      speedPos,
+		prevPos:    pos,
 		brightness: 0,
+		hue:        hue,
+		sat:        sat,
 	}
 	return star
 }
 
 func (s *Stars) update(elapsed float32) {
+	// warp speed controls how fast stars approach the camera along z
+	warpSpeed := s.minWarpFactor * zFar
 	// for each star
 	for i := range s.stars {
 		star := &s.stars[i]
-		// update the position of the star based on its speed and the elapsed time since the last update, multiplied by a warp factor to make the stars move faster
-		star.pos.x += star.speed.x * s.minWarpFactor
-		star.pos.y += star.speed.y * s.minWarpFactor
+		// remember where the star was projected last frame before mutating pos, so draw can
+		// stroke a streak between the two
+		prevScreenX, prevScreenY, prevOk := project(star.pos)
+		star.prevPos = star.pos
 
-		// when a star goes out of the window bounds, we reset it to a new random position and speed, and set its brightness back to 0.
-		if star.pos.x < 0 || star.pos.x >= windowWidth || star.pos.y < 0 || star.pos.y >= windowHeight {
+		// bring the star closer to the camera; this is what used to be a flat 2D speed multiplier
+		star.pos.z -= warpSpeed
+
+		sx, sy, ok := project(star.pos)
+		if star.pos.z <= zNear || !ok {
+			// the star has passed the camera or left the viewport: respawn it at the far plane
 			s.stars[i] = newStar()
+			continue
+		}
+		star.screenX, star.screenY, star.onScreen = sx, sy, true
+		if prevOk {
+			star.prevScreenX, star.prevScreenY = prevScreenX, prevScreenY
 		} else {
-			// Otherwise, if the star is still within the bounds, we increase its brightness gradually until it reaches 255 (fully bright).
-			if star.brightness < 255 {
-				star.brightness += byte(5)
-			}
+			star.prevScreenX, star.prevScreenY = sx, sy
+		}
+
+		// brightness grows as the star approaches, so it fades in out of the distance
+		star.brightness = byte(255 * (zFar - star.pos.z) / zFar)
+
+		// brightness modulates V, not the individual RGB channels, so ramping up preserves hue
+		v := float64(star.brightness) / 255
+		if s.colorMode {
+			star.color = hsvToRGB(star.hue, star.sat, v)
+		} else {
+			star.color = rgb{r: star.brightness, g: star.brightness, b: star.brightness}
 		}
-		// assign to write the star to the star tracker
-		s.stars[i] = *star
+	}
+}
+
+// a unit of work handed to a renderPool worker: clear rows [yStart, yEnd) of pixels and
+// rasterize stars, which must all project into that row range
+type tileJob struct {
+	pixels       []byte
+	stars        []Star
+	yStart, yEnd int
+}
+
+// renderPool is a persistent set of worker goroutines, one per horizontal tile of the
+// framebuffer (tileCount == runtime.NumCPU()), fed via per-worker channels. Keeping the workers
+// alive across frames means a frame's rendering cost is a channel send plus a WaitGroup.Wait,
+// rather than the cost of spawning and tearing down goroutines every frame.
+type renderPool struct {
+	tileCount  int
+	tileHeight int
+	jobs       []chan tileJob
+	wg         sync.WaitGroup
+}
+
+func newRenderPool() *renderPool {
+	tileCount := runtime.NumCPU()
+	p := &renderPool{
+		tileCount:  tileCount,
+		tileHeight: (windowHeight + tileCount - 1) / tileCount,
+		jobs:       make([]chan tileJob, tileCount),
+	}
+	for i := range p.jobs {
+		p.jobs[i] = make(chan tileJob)
+		go p.worker(p.jobs[i])
+	}
+	return p
+}
+
+func (p *renderPool) worker(jobs <-chan tileJob) {
+	for job := range jobs {
+		clearTile(job.pixels, job.yStart, job.yEnd)
+		for _, star := range job.stars {
+			drawStreak(job.pixels, star.prevScreenX, star.prevScreenY, star.screenX, star.screenY, star.color, job.yStart, job.yEnd)
+		}
+		p.wg.Done()
+	}
+}
+
+// draw clears pixels and rasterizes stars, tiling the framebuffer across the worker pool.
+// stars are first bucketed by which tile their projected y falls into, so each worker only
+// ever touches the rows it owns; a streak long enough to cross into a neighbouring tile is
+// clipped at the boundary rather than handed to both workers, to avoid a data race between them.
+func (p *renderPool) draw(pixels []byte, stars []Star) {
+	buckets := make([][]Star, p.tileCount)
+	for _, star := range stars {
+		if !star.onScreen {
+			continue
+		}
+		tile := star.screenY / p.tileHeight
+		buckets[tile] = append(buckets[tile], star)
+	}
+
+	p.wg.Add(p.tileCount)
+	for i := 0; i < p.tileCount; i++ {
+		yStart := i * p.tileHeight
+		yEnd := yStart + p.tileHeight
+		if yEnd > windowHeight {
+			yEnd = windowHeight
+		}
+		p.jobs[i] <- tileJob{pixels: pixels, stars: buckets[i], yStart: yStart, yEnd: yEnd}
+	}
+	p.wg.Wait()
+}
+
+// drawSequential is the single-threaded equivalent of renderPool.draw, kept around to give the
+// tiled renderer something to benchmark against.
+func drawSequential(pixels []byte, stars []Star) {
+	clearTile(pixels, 0, windowHeight)
+	for _, star := range stars {
+		if !star.onScreen {
+			continue
+		}
+		drawStreak(pixels, star.prevScreenX, star.prevScreenY, star.screenX, star.screenY, star.color, 0, windowHeight)
 	}
 }
 
 func (s *Stars) draw(pixels []byte) {
-	// for every star
-	for i := range s.stars {
-		star := s.stars[i]
-		// set the pixel in the pixel buffer using the current status of the star (position and brightness)
-		setPixel(pixels, int(star.pos.x), int(star.pos.y), star.brightness)
+	if s.pool == nil {
+		s.pool = newRenderPool()
+	}
+	s.pool.draw(pixels, s.stars)
+}
+
+// replayEvent is one recorded key press: enough, together with the run's seed, to reproduce the
+// starfield frame-for-frame without live keyboard input or wall-clock timing.
+type replayEvent struct {
+	Frame      uint32
+	Seed       uint64
+	WarpFactor float64
+	Key        int32 // sdl keycode of the key that was pressed
+}
+
+// replayWriter appends replayEvents to a binary log, for -record.
+type replayWriter struct {
+	f *os.File
+}
+
+func newReplayWriter(path string) (*replayWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &replayWriter{f: f}, nil
+}
+
+func (w *replayWriter) write(e replayEvent) error {
+	return binary.Write(w.f, binary.LittleEndian, e)
+}
+
+func (w *replayWriter) Close() error {
+	return w.f.Close()
+}
+
+// replayReader reads replayEvents back in recorded order, for -replay.
+type replayReader struct {
+	f *os.File
+}
+
+func newReplayReader(path string) (*replayReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	return &replayReader{f: f}, nil
+}
+
+// next returns the next recorded event, or ok=false once the log is exhausted.
+func (r *replayReader) next() (e replayEvent, ok bool) {
+	if err := binary.Read(r.f, binary.LittleEndian, &e); err != nil {
+		return replayEvent{}, false
+	}
+	return e, true
+}
+
+func (r *replayReader) Close() error {
+	return r.f.Close()
+}
+
+// applyKeyEvent updates state in response to a single key press. It's shared between live SDL
+// input and -replay playback so both drive the simulation identically. Returns true if the key
+// should end the program.
+func applyKeyEvent(all *Stars, key sdl.Keycode) (quit bool) {
+	switch key {
+	case sdl.K_ESCAPE:
+		return true
+	case sdl.K_UP:
+		all.minWarpFactor += 0.01
+	case sdl.K_DOWN:
+		// never let warp go negative: that would send z increasing instead of decreasing, so
+		// stars would never reach zNear and respawn, and the brightness byte conversion in
+		// Stars.update would wrap on the resulting out-of-range value
+		all.minWarpFactor = math.Max(0, all.minWarpFactor-0.01)
+	case sdl.K_c:
+		all.colorMode = !all.colorMode
+	}
+	return false
 }
 
 func main() {
+	seed := flag.Uint64("seed", uint64(time.Now().UnixNano()), "seed for the star RNG (default: time-based)")
+	recordPath := flag.String("record", "", "record key events to this file, for later -replay")
+	replayPath := flag.String("replay", "", "replay a previously -recorded file instead of live input")
+	profile := flag.String("profile", "disk", "stellar spawn distribution: disk, bulge, exponential, or hernquist")
+	flag.Parse()
+
+	if *recordPath != "" && *replayPath != "" {
+		panic("-record and -replay are mutually exclusive")
+	}
+
+	fn, ok := spawnFuncs[*profile]
+	if !ok {
+		panic(fmt.Sprintf("unknown -profile %q", *profile))
+	}
+	spawnFunc = fn
+
+	var recorder *replayWriter
+	var replay *replayReader
+	var nextReplayEvent replayEvent
+	var haveReplayEvent bool
+	switch {
+	case *recordPath != "":
+		w, err := newReplayWriter(*recordPath)
+		if err != nil {
+			panic(err)
+		}
+		defer w.Close()
+		recorder = w
+	case *replayPath != "":
+		r, err := newReplayReader(*replayPath)
+		if err != nil {
+			panic(err)
+		}
+		defer r.Close()
+		replay = r
+
+		// reseed from the run that was recorded, not whatever -seed happens to be on the
+		// command line now, so playback actually reconstructs that run's starfield
+		nextReplayEvent, haveReplayEvent = replay.next()
+		if haveReplayEvent {
+			*seed = nextReplayEvent.Seed
+		}
+	}
+
+	fmt.Printf("using seed %d\n", *seed)
+	seedRNG(*seed)
+
 	err := sdl.Init(sdl.INIT_EVERYTHING)
 	if err != nil {
 		panic(err)
@@ -160,38 +630,60 @@ func main() {
 		all.update(0)
 	}
 
-	// initialize the pixel buffer to black before we start the main loop
-	clearPixels(pixels)
+	var frameNum uint32
 
 	for {
 		frameStart := time.Now()
-		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-			switch e := event.(type) {
-			case *sdl.QuitEvent:
-				return
-			case *sdl.KeyboardEvent:
-				if e.Keysym.Sym == sdl.K_ESCAPE && e.Type == sdl.KEYDOWN {
-					//fmt.Printf("Pressed %+v\n", e)
+
+		if replay != nil {
+			// drive state purely from the log instead of live input, so playback doesn't
+			// depend on wall-clock timing; the run ends when the log does
+			for haveReplayEvent && nextReplayEvent.Frame == frameNum {
+				if applyKeyEvent(all, sdl.Keycode(nextReplayEvent.Key)) {
 					return
 				}
-				if e.Keysym.Sym == sdl.K_UP && e.Type == sdl.KEYDOWN {
-					all.minWarpFactor += 0.01
-				}
-				if e.Keysym.Sym == sdl.K_DOWN && e.Type == sdl.KEYDOWN {
-					all.minWarpFactor -= 0.01
+				nextReplayEvent, haveReplayEvent = replay.next()
+			}
+			if !haveReplayEvent {
+				return
+			}
+		} else {
+			for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+				switch e := event.(type) {
+				case *sdl.QuitEvent:
+					return
+				case *sdl.KeyboardEvent:
+					if e.Type != sdl.KEYDOWN {
+						continue
+					}
+					quit := applyKeyEvent(all, e.Keysym.Sym)
+					if recorder != nil {
+						if err := recorder.write(replayEvent{
+							Frame:      frameNum,
+							Seed:       *seed,
+							WarpFactor: all.minWarpFactor,
+							Key:        int32(e.Keysym.Sym),
+						}); err != nil {
+							panic(err)
+						}
+					}
+					if quit {
+						return
+					}
 				}
 			}
 		}
+
 		all.update(elapsed)
 		all.draw(pixels)
 		texture.Update(nil, unsafe.Pointer(&pixels[0]), windowWidth*4)
 		renderer.Copy(texture, nil, nil)
 		renderer.Present()
-		clearPixels(pixels)
 		elapsed = float32(time.Since(frameStart).Seconds() * 1000)
 		if elapsed < 16 {
 			sdl.Delay(uint32(16 - elapsed))
 			elapsed = float32(time.Since(frameStart).Seconds() * 1000)
 		}
+		frameNum++
 	}
 }
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+// benchNumStars is deliberately large so the tiled renderer has enough work per tile to
+// amortize the cost of bucketing and dispatch.
+const benchNumStars = 100_000
+
+func benchStars(n int) []Star {
+	stars := make([]Star, n)
+	for i := range stars {
+		stars[i] = newStar()
+	}
+	all := &Stars{stars: stars, minWarpFactor: 0.05}
+	for range 60 {
+		all.update(0)
+	}
+	return all.stars
+}
+
+func BenchmarkDrawSequential(b *testing.B) {
+	pixels := make([]byte, windowWidth*windowHeight*4)
+	stars := benchStars(benchNumStars)
+
+	b.ResetTimer()
+	for range b.N {
+		drawSequential(pixels, stars)
+	}
+}
+
+func BenchmarkDrawTiled(b *testing.B) {
+	pixels := make([]byte, windowWidth*windowHeight*4)
+	stars := benchStars(benchNumStars)
+	pool := newRenderPool()
+
+	b.ResetTimer()
+	for range b.N {
+		pool.draw(pixels, stars)
+	}
+}